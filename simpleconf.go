@@ -7,11 +7,39 @@
 // Section header: A section header names the current section. The Section named is wrapped in '[' and ']'.
 // Section names must not be empty.
 //
+// A section header may also carry a subsection, as `[section "subsection"]`. The subsection
+// name is a quoted string with the same escape rules as quoted values (see below), and lets
+// the same section name be repeated with a different subsection, e.g. `[remote "origin"]` and
+// `[remote "upstream"]`. The deprecated `[section.subsection]` form (a literal '.' in the
+// section name) is rejected.
+//
 // Key-Value Pair: A value assigned to a key. The pair must belong to a section.
 // Key and value are separated by '='. Everything after the '=' is the value.
 // Keys must not be empty.
 // Leading and trailing whitespace in key and value will be deleted.
 //
+// If, after trimming, the value starts with a '"', it is instead parsed as a quoted value:
+// everything up to the next unescaped '"' is taken as the value verbatim, including leading/trailing
+// whitespace and ';'/'#' characters, and anything after the closing '"' must be whitespace or a comment.
+// Quoted values support the escape sequences \\, \", \n, \t, \b and \<newline>, the last of which
+// continues the value on the following line. A comment after the closing '"' is the only kind
+// of comment that can share a line with anything else; in an unquoted value, ';' and '#' are
+// always part of the value.
+//
+// Include directive: an `include = path` key-value pair, either before any section header or
+// inside a `[include]` section, causes the named file to be parsed in place of the directive,
+// as if its lines appeared there. Relative paths are resolved by LoadFile against the
+// including file's directory. Include cycles and chains deeper than 10 files are rejected.
+//
+// LoadWithOptions can additionally interpolate `${env:VAR}` and `${section.key}` references in
+// values; see LoadOptions.
+//
+// Syntax errors from Load are returned as a *ParseError, pinning the problem to a line and
+// column and, where applicable, the section and key being parsed; see ParseError.Reason for the
+// possible causes. Failures from the `Get...` functions are returned as a *LookupError wrapping
+// NotFound, NotBool or, for GetInt and GetFloat, the strconv parse error, so existing
+// `errors.Is(err, NotFound)` checks keep working.
+//
 // Example:
 //
 // 	[foo]
@@ -26,36 +54,368 @@ package simpleconf
 
 import (
 	"bufio"
+	"encoding"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// Config contains a loaded config file. You can access the values by simply using the map or by using the `Get...` functions.
+// Config contains a loaded config file, keyed by section name, for headers without a
+// subsection (`[section]`, as opposed to `[section "subsection"]`). You can access the values
+// directly via the map, as c[section][key], or by using the `Get...` functions, which operate
+// on the subsection-less section unless their name says otherwise (e.g. GetStringSub).
+//
+// Headers that do carry a subsection are stored internally under a different key and are not
+// reachable by indexing c with the section name alone; use the `Get...Sub` functions, or
+// range over c and split each key with splitSectionKey, to get at them.
 type Config map[string]Section
-type Section map[string]string
 
-func (c Config) addSection(section Section, name string) {
-	if section != nil {
-		c[name] = section
+// Section holds the values of a config section. A key maps to a slice because a key may be
+// assigned to more than once within a section; the `Get...` functions return the last value,
+// and GetStrings returns all of them in the order they appeared.
+type Section map[string][]string
+
+// sectionKeySep separates a section name from its subsection in the internal key under which
+// Config stores a subsectioned header. Set and Save reject section names containing it (see
+// validName); Load does not check for it, so a header whose name contains a NUL byte is read
+// back as a subsectioned one.
+const sectionKeySep = "\x00"
+
+// sectionKey returns the Config key under which name/subname is stored.
+func sectionKey(name, subname string) string {
+	if subname == "" {
+		return name
+	}
+	return name + sectionKeySep + subname
+}
+
+// splitSectionKey reverses sectionKey, splitting a Config key back into its section and
+// subsection name.
+func splitSectionKey(key string) (name, subname string) {
+	if i := strings.IndexByte(key, 0); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return key, ""
+}
+
+// Order records the keys (see sectionKey) of a Config's sections in the order they were
+// first added, so Save can reproduce it. Config itself has to stay a plain map that callers
+// can range over directly (see its doc), so this is tracked separately instead of living in
+// an entry of the map; Load and LoadFile return one alongside the Config they build, and Set
+// appends to one explicitly.
+type Order []string
+
+// addSection adds section under name/subname, merging it key-by-key into any section already
+// present there (e.g. from an earlier occurrence of the same header, or an include), so that
+// a key redefined in the later occurrence overrides its old value, while a key the later
+// occurrence doesn't mention keeps the one from the earlier occurrence.
+func (c Config) addSection(section Section, name, subname string) {
+	if section == nil {
+		return
+	}
+
+	key := sectionKey(name, subname)
+	existing, ok := c[key]
+	if !ok {
+		c[key] = section
+		return
+	}
+	for k, vals := range section {
+		existing[k] = vals
 	}
 }
 
+// section returns the Section for name/subname, and whether it exists.
+func (c Config) section(name, subname string) (Section, bool) {
+	s, ok := c[sectionKey(name, subname)]
+	return s, ok
+}
+
+// maxIncludeDepth caps how deeply `include` directives may nest, to guard against runaway
+// recursion.
+const maxIncludeDepth = 10
+
 // Load loads a config file. See package description for the file format.
 //
 // If outerr != nil, either an I/O error occurred, or the file was not a valid config file.
 // In both cases, the error will describe what went wrong.
-func Load(r io.Reader) (config Config, outerr error) {
-	config = make(Config)
-	scanner := bufio.NewScanner(r)
+//
+// Relative paths given to an `include` directive in r itself are resolved against the current
+// working directory, since an io.Reader has no location of its own; use LoadFile to resolve them
+// against the including file's directory instead. Relative paths in an included file are always
+// resolved against that file's directory.
+func Load(r io.Reader) (config Config, order Order, outerr error) {
+	ps := &parseState{config: make(Config), included: make(map[string]bool)}
+	if err := ps.loadFrom(r, "", 0); err != nil {
+		return nil, nil, err
+	}
+	ps.flush()
+	return ps.config, ps.order, nil
+}
+
+// LoadFile is like Load, but reads the config from the file at path. Unlike Load, relative
+// paths given to an `include` directive are resolved against the directory containing path.
+func LoadFile(path string) (Config, Order, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	ps := &parseState{config: make(Config), included: map[string]bool{abs: true}}
+	if err := ps.loadFrom(f, filepath.Dir(abs), 0); err != nil {
+		return nil, nil, err
+	}
+	ps.flush()
+	return ps.config, ps.order, nil
+}
+
+// LoadOptions configures the additional, optional post-processing performed by LoadWithOptions.
+type LoadOptions struct {
+	// Interpolate enables ${env:VAR} and ${section.key} expansion in values; see LoadWithOptions.
+	Interpolate bool
+}
+
+// maxInterpolationDepth caps the number of nested references resolved while expanding a
+// single value, matching goconfig's _DEPTH_VALUES, to guard against reference cycles.
+const maxInterpolationDepth = 200
+
+// LoadWithOptions is like Load, with additional processing controlled by opts.
+//
+// With opts.Interpolate set, every value may contain `${env:VAR}` references, expanded via
+// os.Getenv, and `${section.key}` references, expanded to the (interpolated) value of key in
+// section. References are resolved once the whole file has been parsed, so forward references
+// to a key defined later in the file work. A literal '$' followed by another '$' is not the
+// start of a reference and collapses to a single '$'. A reference chain nested deeper than 200
+// expansions is rejected with an error naming the chain.
+func LoadWithOptions(r io.Reader, opts LoadOptions) (Config, Order, error) {
+	config, order, err := Load(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if opts.Interpolate {
+		if err := config.interpolate(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return config, order, nil
+}
+
+// interpolate expands ${env:VAR} and ${section.key} references in every value of c, in place.
+func (c Config) interpolate() error {
+	// References are resolved against the raw values, so the results are collected separately
+	// and only written back once every value has been expanded.
+	results := make(map[string]map[string][]string, len(c))
+	for sectKey, section := range c {
+		sectName, _ := splitSectionKey(sectKey)
+		results[sectKey] = make(map[string][]string, len(section))
+		for key, vals := range section {
+			out := make([]string, len(vals))
+			for i, val := range vals {
+				expanded, err := c.expand(val, fmt.Sprintf("%s.%s", sectName, key), 0)
+				if err != nil {
+					return err
+				}
+				out[i] = expanded
+			}
+			results[sectKey][key] = out
+		}
+	}
+	for sectKey, section := range results {
+		for key, vals := range section {
+			c[sectKey][key] = vals
+		}
+	}
+	return nil
+}
+
+// expand resolves ${...} references in val. chain describes the reference path that led here,
+// for error messages; depth counts the references already resolved along that path.
+func (c Config) expand(val, chain string, depth int) (string, error) {
+	if depth > maxInterpolationDepth {
+		return "", fmt.Errorf("Interpolation depth exceeded %d while expanding %s", maxInterpolationDepth, chain)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(val); {
+		switch {
+		case val[i] == '$' && i+1 < len(val) && val[i+1] == '$':
+			b.WriteByte('$')
+			i += 2
+		case val[i] == '$' && i+1 < len(val) && val[i+1] == '{':
+			end := strings.IndexByte(val[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("Unterminated '${' interpolation in %s", chain)
+			}
+
+			ref := val[i+2 : i+2+end]
+			resolved, err := c.resolveRef(ref, chain, depth+1)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(resolved)
+			i += 2 + end + 1
+		default:
+			b.WriteByte(val[i])
+			i++
+		}
+	}
+
+	return b.String(), nil
+}
+
+// resolveRef expands a single reference, the part between "${" and "}".
+func (c Config) resolveRef(ref, chain string, depth int) (string, error) {
+	if strings.HasPrefix(ref, "env:") {
+		return os.Getenv(ref[len("env:"):]), nil
+	}
+
+	// Section names cannot contain '.' (see parseSectionHeader), so the first '.' always
+	// separates the section from the key; the key itself may contain further dots.
+	parts := strings.SplitN(ref, ".", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("Invalid interpolation reference ${%s} in %s", ref, chain)
+	}
+
+	raw, err := c.GetString(parts[0], parts[1])
+	if err != nil {
+		return "", fmt.Errorf("Undefined interpolation reference ${%s} in %s", ref, chain)
+	}
+
+	return c.expand(raw, chain+" -> "+ref, depth)
+}
+
+// ParseReason identifies the kind of syntax error a ParseError describes.
+type ParseReason int
+
+const (
+	// ErrMalformedLine: the line is neither a comment, a section header nor a key-value pair.
+	ErrMalformedLine ParseReason = iota
+	// ErrEmptyKey: a key-value pair's key is empty.
+	ErrEmptyKey
+	// ErrNoSection: a key-value pair appears before any section header.
+	ErrNoSection
+	// ErrEmptySection: a section header's name is empty.
+	ErrEmptySection
+	// ErrDeprecatedSubsection: a section header uses the unsupported '[section.subsection]' form.
+	ErrDeprecatedSubsection
+	// ErrMissingCloseBracket: a section header is missing its closing ']'.
+	ErrMissingCloseBracket
+	// ErrMoreDataAfterBracket: a section header has trailing data after its closing ']'.
+	ErrMoreDataAfterBracket
+	// ErrUnterminatedQuote: a quoted value or subsection name has no closing '"'.
+	ErrUnterminatedQuote
+	// ErrMoreDataAfterQuote: a quoted value has trailing data after its closing '"' that is not a comment.
+	ErrMoreDataAfterQuote
+	// ErrInvalidEscape: a quoted value or subsection name contains an unknown '\' escape sequence.
+	ErrInvalidEscape
+	// ErrIncludeCycle: an `include` directive refers back to a file already being parsed.
+	ErrIncludeCycle
+	// ErrIncludeDepthExceeded: `include` directives are nested deeper than maxIncludeDepth.
+	ErrIncludeDepthExceeded
+)
 
-	var section Section
-	var sectName string
+// String returns a human-readable description of r.
+func (r ParseReason) String() string {
+	switch r {
+	case ErrMalformedLine:
+		return "line is neither a comment, a section header nor a key-value pair"
+	case ErrEmptyKey:
+		return "empty key"
+	case ErrNoSection:
+		return "found key-value pair, but no section"
+	case ErrEmptySection:
+		return "empty section name"
+	case ErrDeprecatedSubsection:
+		return `the deprecated '[section.subsection]' form is not supported, use '[section "subsection"]' instead`
+	case ErrMissingCloseBracket:
+		return "missing closing ']' in section header"
+	case ErrMoreDataAfterBracket:
+		return "more data after closing ']'"
+	case ErrUnterminatedQuote:
+		return "unterminated quoted value"
+	case ErrMoreDataAfterQuote:
+		return "more data after closing '\"'"
+	case ErrInvalidEscape:
+		return "invalid escape sequence"
+	case ErrIncludeCycle:
+		return "include cycle detected"
+	case ErrIncludeDepthExceeded:
+		return "include depth exceeded"
+	default:
+		return "parse error"
+	}
+}
+
+// ParseError is returned by Load (and LoadFile) for a syntax error in the config file, pinning
+// it to the line and, where meaningful, the column it occurred at, along with the section and
+// key being parsed, if any.
+type ParseError struct {
+	Line    int
+	Col     int
+	Section string
+	Key     string
+	Reason  ParseReason
+}
+
+func (e *ParseError) Error() string {
+	where := fmt.Sprintf("line %d, column %d", e.Line, e.Col)
+	switch {
+	case e.Section != "" && e.Key != "":
+		return fmt.Sprintf("%s at %s ([%s] %s)", e.Reason, where, e.Section, e.Key)
+	case e.Section != "":
+		return fmt.Sprintf("%s at %s ([%s])", e.Reason, where, e.Section)
+	case e.Key != "":
+		return fmt.Sprintf("%s at %s (%s)", e.Reason, where, e.Key)
+	default:
+		return fmt.Sprintf("%s at %s", e.Reason, where)
+	}
+}
+
+// parseState carries the section currently being built and the bookkeeping needed to follow
+// `include` directives across loadFrom calls, one per included file.
+type parseState struct {
+	config   Config
+	order    Order
+	section  Section
+	sectName string
+	subName  string
+	included map[string]bool
+}
+
+// flush adds the section currently being built to ps.config, recording its key in ps.order if
+// this is the first time it's seen.
+func (ps *parseState) flush() {
+	if ps.section == nil {
+		return
+	}
+	key := sectionKey(ps.sectName, ps.subName)
+	if _, ok := ps.config[key]; !ok {
+		ps.order = append(ps.order, key)
+	}
+	ps.config.addSection(ps.section, ps.sectName, ps.subName)
+}
 
-	for l := 1; scanner.Scan(); l++ {
+func (ps *parseState) loadFrom(r io.Reader, baseDir string, depth int) error {
+	scanner := bufio.NewScanner(r)
+
+	l := 0
+	for scanner.Scan() {
+		l++
 		line := strings.TrimSpace(scanner.Text())
 		if len(line) == 0 {
 			continue
@@ -65,63 +425,280 @@ func Load(r io.Reader) (config Config, outerr error) {
 		case ';', '#':
 			continue
 		case '[':
-			parts := strings.SplitN(line, "[", 2)
-			parts = strings.SplitN(parts[1], "]", 2)
-
-			if len(parts) != 2 {
-				return nil, fmt.Errorf("Missing closing ']' in section header at line %d", l)
-			}
-			if len(parts[1]) != 0 {
-				return nil, fmt.Errorf("More data after closing ']' at line %d", l)
-			}
-			if len(parts[0]) == 0 {
-				return nil, fmt.Errorf("Empty section name at line %d", l)
+			name, sub, err := parseSectionHeader(line, l)
+			if err != nil {
+				return err
 			}
 
-			config.addSection(section, sectName)
-			section = make(Section)
-			sectName = parts[0]
+			ps.flush()
+			ps.section = make(Section)
+			ps.sectName, ps.subName = name, sub
 		default:
 			parts := strings.SplitN(line, "=", 2)
 			if len(parts) != 2 {
-				return nil, fmt.Errorf("Couldn't neither find a comment, a section header nor a key-value pair at line %d", l)
+				return &ParseError{Line: l, Col: 1, Reason: ErrMalformedLine}
 			}
 
 			key := strings.TrimSpace(parts[0])
-			val := strings.TrimSpace(parts[1])
 			if len(key) == 0 {
-				return nil, fmt.Errorf("Empty key at line %d", l)
+				return &ParseError{Line: l, Col: 1, Reason: ErrEmptyKey, Section: ps.sectName}
+			}
+
+			valPart := strings.TrimSpace(parts[1])
+
+			var val string
+			if strings.HasPrefix(valPart, `"`) {
+				var err error
+				val, err = parseQuotedValue(valPart, scanner, &l)
+				if err != nil {
+					if pe, ok := err.(*ParseError); ok {
+						pe.Section, pe.Key = ps.sectName, key
+					}
+					return err
+				}
+			} else {
+				val = valPart
+			}
+
+			if key == "include" && (ps.section == nil || (ps.sectName == "include" && ps.subName == "")) {
+				if err := ps.include(val, baseDir, depth, l); err != nil {
+					return err
+				}
+				continue
 			}
 
-			if section == nil {
-				return nil, fmt.Errorf("Found key-value pair, but no section at line %d", l)
+			if ps.section == nil {
+				return &ParseError{Line: l, Col: 1, Reason: ErrNoSection, Key: key}
 			}
 
-			section[key] = val
+			ps.section[key] = append(ps.section[key], val)
 		}
 	}
 
-	config.addSection(section, sectName)
+	return scanner.Err()
+}
 
-	outerr = scanner.Err()
-	return
+// include resolves and parses the file named by path, which was named in an `include`
+// directive found at line l of the file rooted at baseDir, merging its sections into ps as if
+// its lines appeared in place of the directive.
+func (ps *parseState) include(path, baseDir string, depth, l int) error {
+	if depth >= maxIncludeDepth {
+		return &ParseError{Line: l, Col: 1, Reason: ErrIncludeDepthExceeded, Key: "include"}
+	}
+
+	resolved := path
+	if baseDir != "" && !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(baseDir, resolved)
+	}
+
+	abs, err := filepath.Abs(resolved)
+	if err != nil {
+		return fmt.Errorf("could not resolve include path %q at line %d: %w", path, l, err)
+	}
+	if ps.included[abs] {
+		return &ParseError{Line: l, Col: 1, Reason: ErrIncludeCycle, Key: "include"}
+	}
+
+	f, err := os.Open(resolved)
+	if err != nil {
+		return fmt.Errorf("could not open included file %q at line %d: %w", path, l, err)
+	}
+	defer f.Close()
+
+	ps.included[abs] = true
+	defer delete(ps.included, abs)
+	return ps.loadFrom(f, filepath.Dir(abs), depth+1)
+}
+
+// parseSectionHeader parses a `[section]` or `[section "subsection"]` header, with line already
+// trimmed and starting with '['. It returns the section name and, if present, the subsection name.
+func parseSectionHeader(line string, l int) (name, subname string, err error) {
+	body := line[1:]
+
+	i := 0
+	for i < len(body) && body[i] != ']' && body[i] != ' ' && body[i] != '\t' && body[i] != '"' {
+		i++
+	}
+	name = body[:i]
+	if len(name) == 0 {
+		return "", "", &ParseError{Line: l, Col: 2, Reason: ErrEmptySection}
+	}
+	if strings.Contains(name, ".") {
+		return "", "", &ParseError{Line: l, Col: 2, Reason: ErrDeprecatedSubsection}
+	}
+
+	rest := strings.TrimLeft(body[i:], " \t")
+	if strings.HasPrefix(rest, `"`) {
+		subname, rest, err = parseQuotedHeaderPart(rest, l)
+		if err != nil {
+			return "", "", err
+		}
+		rest = strings.TrimLeft(rest, " \t")
+	}
+
+	if len(rest) == 0 || rest[0] != ']' {
+		return "", "", &ParseError{Line: l, Col: len(line) + 1, Reason: ErrMissingCloseBracket, Section: name}
+	}
+	if len(rest) != 1 {
+		return "", "", &ParseError{Line: l, Col: len(line) - len(rest) + 2, Reason: ErrMoreDataAfterBracket, Section: name}
+	}
+
+	return name, subname, nil
+}
+
+// parseQuotedHeaderPart parses a double-quoted subsection name, starting at the opening '"'
+// in s, and returns it along with the remainder of s after the closing '"'. Unlike quoted
+// values, a subsection name must fit on a single line.
+func parseQuotedHeaderPart(s string, l int) (value, rest string, err error) {
+	var b strings.Builder
+
+	for i := 1; i < len(s); {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", "", &ParseError{Line: l, Col: i + 1, Reason: ErrUnterminatedQuote}
+			}
+
+			switch e := s[i+1]; e {
+			case '\\', '"':
+				b.WriteByte(e)
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			default:
+				return "", "", &ParseError{Line: l, Col: i + 1, Reason: ErrInvalidEscape}
+			}
+			i += 2
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return "", "", &ParseError{Line: l, Col: len(s) + 1, Reason: ErrUnterminatedQuote}
+}
+
+// Unmarshal loads a config file from r and decodes it into v. See Config.Decode for the
+// rules used to populate v.
+func Unmarshal(r io.Reader, v interface{}) error {
+	c, _, err := Load(r)
+	if err != nil {
+		return err
+	}
+	return c.Decode(v)
+}
+
+// parseQuotedValue parses a double-quoted value, starting at the opening '"' in s.
+// It consumes further lines from scanner (advancing *l) if the value is continued with a
+// trailing backslash, and returns an error if the value is never terminated.
+func parseQuotedValue(s string, scanner *bufio.Scanner, l *int) (string, error) {
+	var val strings.Builder
+
+	for i := 1; ; {
+		if i >= len(s) {
+			return "", &ParseError{Line: *l, Col: i + 1, Reason: ErrUnterminatedQuote}
+		}
+
+		switch c := s[i]; c {
+		case '"':
+			rest := strings.TrimSpace(s[i+1:])
+			if len(rest) != 0 && rest[0] != ';' && rest[0] != '#' {
+				return "", &ParseError{Line: *l, Col: i + 2, Reason: ErrMoreDataAfterQuote}
+			}
+			return val.String(), nil
+		case '\\':
+			if i+1 >= len(s) {
+				// Backslash at the end of a line continues the value on the next line.
+				if !scanner.Scan() {
+					return "", &ParseError{Line: *l, Col: i + 1, Reason: ErrUnterminatedQuote}
+				}
+				*l++
+				s = scanner.Text()
+				i = 0
+				continue
+			}
+
+			switch e := s[i+1]; e {
+			case '\\', '"':
+				val.WriteByte(e)
+			case 'n':
+				val.WriteByte('\n')
+			case 't':
+				val.WriteByte('\t')
+			case 'b':
+				val.WriteByte('\b')
+			default:
+				return "", &ParseError{Line: *l, Col: i + 1, Reason: ErrInvalidEscape}
+			}
+			i += 2
+		default:
+			val.WriteByte(c)
+			i++
+		}
+	}
 }
 
-// Errors of the `Get...` functions.
+// Sentinel errors of the `Get...` functions. They are never returned directly; they are always
+// wrapped in a *LookupError, which errors.Is(err, NotFound) (or NotBool) sees through, so callers
+// comparing against them keep working while also being able to errors.As into a *LookupError for
+// the section and key that caused the failure.
 var (
 	NotFound = errors.New("Section or key not found.")
 	NotBool  = errors.New("Could not interpret value as bool.")
 )
 
+// LookupError is returned by the `Get...` functions when section/key cannot be found or its
+// value cannot be interpreted as the requested type. Unwrap returns NotFound, NotBool or, for
+// GetInt and GetFloat, the strconv parse error, so errors.Is against those sentinels still works.
+type LookupError struct {
+	Section, Key string
+	reason       error
+}
+
+func (e *LookupError) Error() string {
+	return fmt.Sprintf("[%s] %s: %s", e.Section, e.Key, e.reason)
+}
+
+func (e *LookupError) Unwrap() error {
+	return e.reason
+}
+
 // GetString gets the string assigned to [section] key. It will return NotFound, if no such key exists.
+// If the key was assigned to more than once, the last value is returned.
 func (c Config) GetString(section, key string) (string, error) {
-	s, ok := c[section]
+	return c.GetStringSub(section, "", key)
+}
+
+// GetStringSub is like GetString, but looks up key in the given subsection of section
+// (see `[section "subsection"]` headers) instead of in the subsection-less section.
+func (c Config) GetStringSub(section, subsection, key string) (string, error) {
+	vals, err := c.GetStringsSub(section, subsection, key)
+	if err != nil {
+		return "", err
+	}
+	return vals[len(vals)-1], nil
+}
+
+// GetStrings is like GetString, but returns every value assigned to [section] key, in the
+// order they appeared in the file.
+func (c Config) GetStrings(section, key string) ([]string, error) {
+	return c.GetStringsSub(section, "", key)
+}
+
+// GetStringsSub is like GetStrings, but looks up key in the given subsection of section.
+func (c Config) GetStringsSub(section, subsection, key string) ([]string, error) {
+	s, ok := c.section(section, subsection)
 	if !ok {
-		return "", NotFound
+		return nil, &LookupError{Section: section, Key: key, reason: NotFound}
 	}
 	rv, ok := s[key]
-	if !ok {
-		return "", NotFound
+	if !ok || len(rv) == 0 {
+		return nil, &LookupError{Section: section, Key: key, reason: NotFound}
 	}
 	return rv, nil
 }
@@ -129,7 +706,7 @@ func (c Config) GetString(section, key string) (string, error) {
 // GetStringDefault is like GetString, but will return d, if the key was not found.
 func (c Config) GetStringDefault(d, section, key string) (string, error) {
 	rv, err := c.GetString(section, key)
-	if err == NotFound {
+	if errors.Is(err, NotFound) {
 		return d, nil
 	}
 	return rv, err
@@ -137,16 +714,25 @@ func (c Config) GetStringDefault(d, section, key string) (string, error) {
 
 // GetInt is like GetString, but will additionally parse the value as an integer. See strconv.ParseInt for possible errors.
 func (c Config) GetInt(section, key string) (int64, error) {
-	s, err := c.GetString(section, key)
+	return c.GetIntSub(section, "", key)
+}
+
+// GetIntSub is like GetInt, but looks up key in the given subsection of section.
+func (c Config) GetIntSub(section, subsection, key string) (int64, error) {
+	s, err := c.GetStringSub(section, subsection, key)
 	if err != nil {
 		return 0, err
 	}
-	return strconv.ParseInt(s, 10, 64)
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, &LookupError{Section: section, Key: key, reason: err}
+	}
+	return n, nil
 }
 
 func (c Config) GetIntDefault(d int64, section, key string) (int64, error) {
 	rv, err := c.GetInt(section, key)
-	if err == NotFound {
+	if errors.Is(err, NotFound) {
 		return d, nil
 	}
 	return rv, err
@@ -154,16 +740,25 @@ func (c Config) GetIntDefault(d int64, section, key string) (int64, error) {
 
 // GetFloat is like GetString, but will additionally parse the value as a float. See strconv.ParseFloat for possible errors.
 func (c Config) GetFloat(section, key string) (float64, error) {
-	s, err := c.GetString(section, key)
+	return c.GetFloatSub(section, "", key)
+}
+
+// GetFloatSub is like GetFloat, but looks up key in the given subsection of section.
+func (c Config) GetFloatSub(section, subsection, key string) (float64, error) {
+	s, err := c.GetStringSub(section, subsection, key)
 	if err != nil {
 		return 0, err
 	}
-	return strconv.ParseFloat(s, 64)
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, &LookupError{Section: section, Key: key, reason: err}
+	}
+	return f, nil
 }
 
 func (c Config) GetFloatDefault(d float64, section, key string) (float64, error) {
 	rv, err := c.GetFloat(section, key)
-	if err == NotFound {
+	if errors.Is(err, NotFound) {
 		return d, nil
 	}
 	return rv, err
@@ -173,10 +768,23 @@ func (c Config) GetFloatDefault(d float64, section, key string) (float64, error)
 //
 // true, on, yes, y and 1 are all true, false, off, no, n, 0 are all false. Other values will result in a NotBool error.
 func (c Config) GetBool(section, key string) (bool, error) {
-	s, err := c.GetString(section, key)
+	return c.GetBoolSub(section, "", key)
+}
+
+// GetBoolSub is like GetBool, but looks up key in the given subsection of section.
+func (c Config) GetBoolSub(section, subsection, key string) (bool, error) {
+	s, err := c.GetStringSub(section, subsection, key)
 	if err != nil {
 		return false, err
 	}
+	b, err := parseBool(s)
+	if err != nil {
+		return false, &LookupError{Section: section, Key: key, reason: err}
+	}
+	return b, nil
+}
+
+func parseBool(s string) (bool, error) {
 	switch strings.ToLower(s) {
 	case "true", "on", "yes", "y", "1":
 		return true, nil
@@ -189,7 +797,7 @@ func (c Config) GetBool(section, key string) (bool, error) {
 
 func (c Config) GetBoolDefault(d bool, section, key string) (bool, error) {
 	rv, err := c.GetBool(section, key)
-	if err == NotFound {
+	if errors.Is(err, NotFound) {
 		return d, nil
 	}
 	return rv, err
@@ -210,3 +818,336 @@ func (c Config) GetFile(flag int, perm os.FileMode, section, key string) (*os.Fi
 func (c Config) GetFileReadonly(section, key string) (*os.File, error) {
 	return c.GetFile(os.O_RDONLY, 0, section, key)
 }
+
+// InvalidName is returned by Set when a section or key name contains a
+// character that cannot be represented in the file format ('=', '[', ']', a newline or a NUL byte).
+var InvalidName = errors.New("Section or key name contains an invalid character.")
+
+func validName(name string) bool {
+	return !strings.ContainsAny(name, "=[]\n\x00")
+}
+
+// Set assigns value to key in section, creating the section if it does not exist yet.
+// Any previous values of key (see GetStrings) are discarded.
+// It returns InvalidName if section or key contains '=', '[', ']', a newline or a NUL byte, as
+// those cannot be represented in the file format written by Save.
+//
+// If order is not nil and section does not exist yet, its key is appended to *order, so that a
+// later Save(*order, w) call will include it; see Order.
+func (c Config) Set(order *Order, section, key, value string) error {
+	if !validName(section) || !validName(key) {
+		return InvalidName
+	}
+
+	s, ok := c[section]
+	if !ok {
+		s = make(Section)
+		c[section] = s
+		if order != nil {
+			*order = append(*order, section)
+		}
+	}
+	s[key] = []string{value}
+	return nil
+}
+
+// RemoveOption removes key from section. It returns true if the key existed.
+func (c Config) RemoveOption(section, key string) bool {
+	s, ok := c[section]
+	if !ok {
+		return false
+	}
+	_, ok = s[key]
+	delete(s, key)
+	return ok
+}
+
+// RemoveSection removes section (without a subsection) entirely. It returns true if the
+// section existed. Any subsections of section are left untouched.
+func (c Config) RemoveSection(section string) bool {
+	_, ok := c[section]
+	delete(c, section)
+	return ok
+}
+
+// needsQuoting reports whether s must be wrapped in double quotes to round-trip through Load.
+func needsQuoting(s string) bool {
+	if s == "" {
+		return false
+	}
+	if strings.TrimSpace(s) != s {
+		return true
+	}
+	return strings.ContainsAny(s, "\"\\;#\n\r\t")
+}
+
+// quoteValue renders s as a double-quoted value using the escape sequences understood by Load.
+func quoteValue(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Save writes c back out in the format accepted by Load, so it can be read back in again.
+//
+// Sections (and subsections) named in order are written first, in that order; order is
+// normally the value returned alongside c by Load, or built up by Set, since Config itself is
+// a plain map with no inherent order of its own. Any section present in c but not in order
+// (e.g. added directly to the map rather than via Set) is appended afterwards in
+// lexicographic order. Keys within a section are written in lexicographic order. Values are
+// quoted automatically where needed so that round-tripping through Load reproduces the
+// original value. Section and key names are only checked with the same rule as Set and are
+// written as they are: a section name containing whitespace, '"' or '.', or a key with leading
+// or trailing whitespace or starting with '#' or ';', is not read back unchanged by Load.
+func (c Config) Save(order Order, w io.Writer) error {
+	seen := make(map[string]bool, len(order))
+	sectKeys := make([]string, 0, len(c))
+	for _, sectKey := range order {
+		if _, ok := c[sectKey]; !ok {
+			// Removed via RemoveSection since being recorded.
+			continue
+		}
+		if seen[sectKey] {
+			continue
+		}
+		seen[sectKey] = true
+		sectKeys = append(sectKeys, sectKey)
+	}
+
+	var rest []string
+	for sectKey := range c {
+		if !seen[sectKey] {
+			rest = append(rest, sectKey)
+		}
+	}
+	sort.Strings(rest)
+	sectKeys = append(sectKeys, rest...)
+
+	for _, sectKey := range sectKeys {
+		sectName, subName := splitSectionKey(sectKey)
+		if !validName(sectName) {
+			return InvalidName
+		}
+
+		if subName == "" {
+			if _, err := fmt.Fprintf(w, "[%s]\n", sectName); err != nil {
+				return err
+			}
+		} else {
+			if _, err := fmt.Fprintf(w, "[%s %s]\n", sectName, quoteValue(subName)); err != nil {
+				return err
+			}
+		}
+
+		section := c[sectKey]
+		keys := make([]string, 0, len(section))
+		for key := range section {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			if !validName(key) {
+				return InvalidName
+			}
+
+			for _, value := range section[key] {
+				if needsQuoting(value) {
+					value = quoteValue(value)
+				}
+				if _, err := fmt.Fprintf(w, "%s = %s\n", key, value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Decode populates v, which must be a pointer to a struct, from c.
+//
+// Each exported top-level field of v must itself be a struct, and maps to a section, by field
+// name or, if present, its `simpleconf:"name"` tag. Each exported field of such a nested struct
+// maps to a key the same way. Supported field types are string, bool, the int/uint/float
+// families, time.Duration, slices of any of those (populated from a repeated key, in file
+// order), and anything implementing encoding.TextUnmarshaler. Sections or keys present in c
+// but missing from v are silently ignored; use DecodeStrict to reject those instead.
+func (c Config) Decode(v interface{}) error {
+	return c.decode(v, false)
+}
+
+// DecodeStrict is like Decode, but returns an error if c contains a section or key that has
+// no corresponding field in v.
+func (c Config) DecodeStrict(v interface{}) error {
+	return c.decode(v, true)
+}
+
+func (c Config) decode(v interface{}, strict bool) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Decode: v must be a pointer to a struct, got %T", v)
+	}
+
+	structVal := rv.Elem()
+	structType := structVal.Type()
+	seenSections := make(map[string]bool, structType.NumField())
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		fv := structVal.Field(i)
+		if fv.Kind() != reflect.Struct {
+			return fmt.Errorf("Decode: field %s must be a struct to receive a config section", field.Name)
+		}
+
+		sectName := fieldName(field)
+		seenSections[sectName] = true
+
+		if section, ok := c.section(sectName, ""); ok {
+			if err := decodeSection(section, fv, strict, sectName); err != nil {
+				return err
+			}
+		}
+	}
+
+	if strict {
+		for sectKey := range c {
+			sectName, _ := splitSectionKey(sectKey)
+			if !seenSections[sectName] {
+				return fmt.Errorf("Decode: unknown section [%s]", sectName)
+			}
+		}
+	}
+
+	return nil
+}
+
+func fieldName(field reflect.StructField) string {
+	if tag := field.Tag.Get("simpleconf"); tag != "" {
+		return tag
+	}
+	return field.Name
+}
+
+func decodeSection(section Section, sv reflect.Value, strict bool, sectName string) error {
+	st := sv.Type()
+	seenKeys := make(map[string]bool, st.NumField())
+
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		key := fieldName(field)
+		seenKeys[key] = true
+
+		if values, ok := section[key]; ok {
+			if err := setField(sv.Field(i), values); err != nil {
+				return fmt.Errorf("Decode: [%s] %s: %s", sectName, key, err)
+			}
+		}
+	}
+
+	if strict {
+		for key := range section {
+			if !seenKeys[key] {
+				return fmt.Errorf("Decode: unknown key [%s] %s", sectName, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func setField(fv reflect.Value, values []string) error {
+	isTextUnmarshaler := false
+	if fv.CanAddr() {
+		_, isTextUnmarshaler = fv.Addr().Interface().(encoding.TextUnmarshaler)
+	}
+
+	if fv.Kind() == reflect.Slice && !isTextUnmarshaler {
+		slice := reflect.MakeSlice(fv.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setScalar(slice.Index(i), value); err != nil {
+				return err
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+
+	return setScalar(fv, values[len(values)-1])
+}
+
+func setScalar(fv reflect.Value, value string) error {
+	if fv.CanAddr() {
+		if tu, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return tu.UnmarshalText([]byte(value))
+		}
+	}
+
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", fv.Type())
+	}
+
+	return nil
+}
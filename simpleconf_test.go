@@ -1,8 +1,12 @@
 package simpleconf
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestConfig(t *testing.T) {
@@ -16,7 +20,7 @@ c= on
 trololo			= 1.5
 file =     /dev/zero`)
 
-	conf, err := Load(r)
+	conf, _, err := Load(r)
 	if err != nil {
 		t.Fatalf("Could not read config: %s", err)
 	}
@@ -50,4 +54,407 @@ file =     /dev/zero`)
 	if s, err := conf.GetStringDefault("?", "baz", "bla"); err != nil || s != "?" {
 		t.Errorf("Unexpected return for [baz] bla: `%s`, %s", s, err)
 	}
+
+	if s := conf["foo"]["b"]; len(s) != 1 || s[0] != "1337" {
+		t.Errorf("Unexpected return for direct access conf[\"foo\"][\"b\"]: %v", s)
+	}
+
+	if len(conf) != 2 {
+		t.Errorf("Expected exactly the 2 parsed sections when ranging over conf directly, got %d: %v", len(conf), conf)
+	}
+}
+
+func TestSave(t *testing.T) {
+	conf := make(Config)
+	var order Order
+	if err := conf.Set(&order, "foo", "a", "Hello, World!"); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if err := conf.Set(&order, "foo", "b", "  padded  "); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	if err := conf.Set(&order, "bad", "k=v", "x"); err != InvalidName {
+		t.Errorf("Expected InvalidName for invalid key, got %s", err)
+	}
+
+	var buf strings.Builder
+	if err := conf.Save(order, &buf); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+
+	reloaded, _, err := Load(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("Could not reload saved config: %s\n%s", err, buf.String())
+	}
+
+	if s, err := reloaded.GetString("foo", "a"); err != nil || s != "Hello, World!" {
+		t.Errorf("Unexpected return for [foo] a after round-trip: `%s`, %s", s, err)
+	}
+
+	if !conf.RemoveOption("foo", "a") {
+		t.Errorf("RemoveOption should have reported the key as removed")
+	}
+	if conf.RemoveOption("foo", "a") {
+		t.Errorf("RemoveOption should report false for an already-removed key")
+	}
+
+	if !conf.RemoveSection("foo") {
+		t.Errorf("RemoveSection should have reported the section as removed")
+	}
+	if _, ok := conf["foo"]; ok {
+		t.Errorf("Section [foo] should be gone after RemoveSection")
+	}
+}
+
+func TestSaveOrder(t *testing.T) {
+	conf := make(Config)
+	var order Order
+	if err := conf.Set(&order, "zzz", "k", "1"); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if err := conf.Set(&order, "aaa", "k", "2"); err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+
+	var buf strings.Builder
+	if err := conf.Save(order, &buf); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+
+	zzzIdx := strings.Index(buf.String(), "[zzz]")
+	aaaIdx := strings.Index(buf.String(), "[aaa]")
+	if zzzIdx < 0 || aaaIdx < 0 || zzzIdx > aaaIdx {
+		t.Errorf("Expected [zzz] before [aaa] (insertion order), got:\n%s", buf.String())
+	}
+}
+
+func TestQuotedValue(t *testing.T) {
+	r := strings.NewReader(`[foo]
+a = "  not trimmed; still not a comment # me neither  " ; but this is
+b = "line one\nline two"
+c = "tab\there"
+d = "a long value that \
+	continues on the next line"`)
+
+	conf, _, err := Load(r)
+	if err != nil {
+		t.Fatalf("Could not read config: %s", err)
+	}
+
+	if s, err := conf.GetString("foo", "a"); err != nil || s != "  not trimmed; still not a comment # me neither  " {
+		t.Errorf("Unexpected return for [foo] a: `%s`, %s", s, err)
+	}
+
+	if s, err := conf.GetString("foo", "b"); err != nil || s != "line one\nline two" {
+		t.Errorf("Unexpected return for [foo] b: `%s`, %s", s, err)
+	}
+
+	if s, err := conf.GetString("foo", "c"); err != nil || s != "tab\there" {
+		t.Errorf("Unexpected return for [foo] c: `%s`, %s", s, err)
+	}
+
+	if s, err := conf.GetString("foo", "d"); err != nil || s != "a long value that \tcontinues on the next line" {
+		t.Errorf("Unexpected return for [foo] d: `%s`, %s", s, err)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	type Foo struct {
+		A       string        `simpleconf:"a"`
+		Answer  int           `simpleconf:"answer"`
+		Timeout time.Duration `simpleconf:"timeout"`
+		Tags    []string      `simpleconf:"tags"`
+	}
+	type Cfg struct {
+		Foo Foo `simpleconf:"foo"`
+	}
+
+	r := strings.NewReader(`[foo]
+a = Hello, World!
+answer = 42
+timeout = 1h30m
+tags = one
+tags = two
+tags = three`)
+
+	var cfg Cfg
+	if err := Unmarshal(r, &cfg); err != nil {
+		t.Fatalf("Unmarshal failed: %s", err)
+	}
+
+	if cfg.Foo.A != "Hello, World!" {
+		t.Errorf("Unexpected value for Foo.A: `%s`", cfg.Foo.A)
+	}
+	if cfg.Foo.Answer != 42 {
+		t.Errorf("Unexpected value for Foo.Answer: %d", cfg.Foo.Answer)
+	}
+	if cfg.Foo.Timeout != 90*time.Minute {
+		t.Errorf("Unexpected value for Foo.Timeout: %s", cfg.Foo.Timeout)
+	}
+	if strings.Join(cfg.Foo.Tags, ",") != "one,two,three" {
+		t.Errorf("Unexpected value for Foo.Tags: %v", cfg.Foo.Tags)
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	type Cfg struct {
+		Foo struct {
+			A string `simpleconf:"a"`
+		} `simpleconf:"foo"`
+	}
+
+	r := strings.NewReader("[foo]\na = 1\nb = 2")
+	conf, _, err := Load(r)
+	if err != nil {
+		t.Fatalf("Could not read config: %s", err)
+	}
+
+	var cfg Cfg
+	if err := conf.Decode(&cfg); err != nil {
+		t.Errorf("Decode should ignore the unknown key b, got %s", err)
+	}
+
+	if err := conf.DecodeStrict(&cfg); err == nil {
+		t.Errorf("DecodeStrict should report the unknown key b")
+	}
+}
+
+func TestSubsection(t *testing.T) {
+	r := strings.NewReader(`[remote "origin"]
+url = git@example.com:origin.git
+[remote "upstream"]
+url = git@example.com:upstream.git
+[core]
+editor = vim`)
+
+	conf, order, err := Load(r)
+	if err != nil {
+		t.Fatalf("Could not read config: %s", err)
+	}
+
+	if s, err := conf.GetStringSub("remote", "origin", "url"); err != nil || s != "git@example.com:origin.git" {
+		t.Errorf(`Unexpected return for [remote "origin"] url: `+"`%s`, %s", s, err)
+	}
+
+	if s, err := conf.GetStringSub("remote", "upstream", "url"); err != nil || s != "git@example.com:upstream.git" {
+		t.Errorf(`Unexpected return for [remote "upstream"] url: `+"`%s`, %s", s, err)
+	}
+
+	if s, err := conf.GetString("core", "editor"); err != nil || s != "vim" {
+		t.Errorf("Unexpected return for [core] editor: `%s`, %s", s, err)
+	}
+
+	var buf strings.Builder
+	if err := conf.Save(order, &buf); err != nil {
+		t.Fatalf("Save failed: %s", err)
+	}
+
+	reloaded, _, err := Load(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("Could not reload saved config: %s\n%s", err, buf.String())
+	}
+	if s, err := reloaded.GetStringSub("remote", "upstream", "url"); err != nil || s != "git@example.com:upstream.git" {
+		t.Errorf(`Unexpected return for [remote "upstream"] url after round-trip: `+"`%s`, %s", s, err)
+	}
+}
+
+func TestDeprecatedSubsectionForm(t *testing.T) {
+	if _, _, err := Load(strings.NewReader("[remote.origin]\nurl = x")); err == nil {
+		t.Errorf("Expected an error for the deprecated '[section.subsection]' form")
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "extra.conf"), []byte("[foo]\nb = from extra\n"), 0644); err != nil {
+		t.Fatalf("Could not write extra.conf: %s", err)
+	}
+
+	mainPath := filepath.Join(dir, "main.conf")
+	if err := os.WriteFile(mainPath, []byte(`include = extra.conf
+[foo]
+a = from main
+`), 0644); err != nil {
+		t.Fatalf("Could not write main.conf: %s", err)
+	}
+
+	conf, _, err := LoadFile(mainPath)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %s", err)
+	}
+
+	if s, err := conf.GetString("foo", "a"); err != nil || s != "from main" {
+		t.Errorf("Unexpected return for [foo] a: `%s`, %s", s, err)
+	}
+	if s, err := conf.GetString("foo", "b"); err != nil || s != "from extra" {
+		t.Errorf("Unexpected return for [foo] b: `%s`, %s", s, err)
+	}
+}
+
+func TestIncludeOverride(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "extra.conf"), []byte("[foo]\nbar = from_extra\n"), 0644); err != nil {
+		t.Fatalf("Could not write extra.conf: %s", err)
+	}
+
+	mainPath := filepath.Join(dir, "main.conf")
+	if err := os.WriteFile(mainPath, []byte(`include = extra.conf
+[foo]
+bar = from_main
+`), 0644); err != nil {
+		t.Fatalf("Could not write main.conf: %s", err)
+	}
+
+	conf, _, err := LoadFile(mainPath)
+	if err != nil {
+		t.Fatalf("LoadFile failed: %s", err)
+	}
+
+	if vals, err := conf.GetStrings("foo", "bar"); err != nil || len(vals) != 1 || vals[0] != "from_main" {
+		t.Errorf(`Expected [foo] bar to be overridden to just ["from_main"], got %v, %s`, vals, err)
+	}
+}
+
+func TestRepeatedSectionOverride(t *testing.T) {
+	r := strings.NewReader("[foo]\na = 1\n[foo]\na = 2\n")
+
+	conf, _, err := Load(r)
+	if err != nil {
+		t.Fatalf("Could not read config: %s", err)
+	}
+
+	if vals, err := conf.GetStrings("foo", "a"); err != nil || len(vals) != 1 || vals[0] != "2" {
+		t.Errorf(`Expected a repeated [foo] to override a to just ["2"], got %v, %s`, vals, err)
+	}
+}
+
+
+func TestIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+
+	aPath := filepath.Join(dir, "a.conf")
+	bPath := filepath.Join(dir, "b.conf")
+
+	if err := os.WriteFile(aPath, []byte("include = b.conf\n"), 0644); err != nil {
+		t.Fatalf("Could not write a.conf: %s", err)
+	}
+	if err := os.WriteFile(bPath, []byte("include = a.conf\n"), 0644); err != nil {
+		t.Fatalf("Could not write b.conf: %s", err)
+	}
+
+	if _, _, err := LoadFile(aPath); err == nil {
+		t.Errorf("Expected an error for an include cycle")
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	os.Setenv("SIMPLECONF_TEST_VAR", "from env")
+	defer os.Unsetenv("SIMPLECONF_TEST_VAR")
+
+	r := strings.NewReader(`[paths]
+root = /srv/app
+data = ${paths.root}/data
+[foo]
+a = ${env:SIMPLECONF_TEST_VAR}
+b = price: $$5
+c = ${paths.data}/more
+d = ${paths.future}
+[paths]
+future = /later`)
+
+	conf, _, err := LoadWithOptions(r, LoadOptions{Interpolate: true})
+	if err != nil {
+		t.Fatalf("LoadWithOptions failed: %s", err)
+	}
+
+	if s, err := conf.GetString("paths", "data"); err != nil || s != "/srv/app/data" {
+		t.Errorf("Unexpected return for [paths] data: `%s`, %s", s, err)
+	}
+	if s, err := conf.GetString("foo", "a"); err != nil || s != "from env" {
+		t.Errorf("Unexpected return for [foo] a: `%s`, %s", s, err)
+	}
+	if s, err := conf.GetString("foo", "b"); err != nil || s != "price: $5" {
+		t.Errorf("Unexpected return for [foo] b: `%s`, %s", s, err)
+	}
+	if s, err := conf.GetString("foo", "c"); err != nil || s != "/srv/app/data/more" {
+		t.Errorf("Unexpected return for [foo] c: `%s`, %s", s, err)
+	}
+	if s, err := conf.GetString("foo", "d"); err != nil || s != "/later" {
+		t.Errorf("Unexpected return for [foo] d (forward reference): `%s`, %s", s, err)
+	}
+}
+
+func TestInterpolationCycle(t *testing.T) {
+	r := strings.NewReader(`[foo]
+a = ${foo.b}
+b = ${foo.a}`)
+
+	if _, _, err := LoadWithOptions(r, LoadOptions{Interpolate: true}); err == nil {
+		t.Errorf("Expected an error for an interpolation reference cycle")
+	}
+}
+
+func TestQuotedValueErrors(t *testing.T) {
+	if _, _, err := Load(strings.NewReader("[foo]\na = \"unterminated")); err == nil {
+		t.Errorf("Expected an error for an unterminated quoted value")
+	}
+
+	if _, _, err := Load(strings.NewReader(`[foo]
+a = "bad\qescape"`)); err == nil {
+		t.Errorf("Expected an error for an invalid escape sequence")
+	}
+
+	if _, _, err := Load(strings.NewReader(`[foo]
+a = "quoted" garbage`)); err == nil {
+		t.Errorf("Expected an error for data trailing a closing quote")
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, _, err := Load(strings.NewReader("[foo]\nbar"))
+	if err == nil {
+		t.Fatalf("Expected an error for a malformed line")
+	}
+
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("Expected err to be a *ParseError, got %T: %s", err, err)
+	}
+	if pe.Line != 2 || pe.Reason != ErrMalformedLine {
+		t.Errorf("Unexpected ParseError: %+v", pe)
+	}
+}
+
+func TestLookupError(t *testing.T) {
+	conf, _, err := Load(strings.NewReader("[foo]\nbar = not a bool"))
+	if err != nil {
+		t.Fatalf("Could not read config: %s", err)
+	}
+
+	_, err = conf.GetString("foo", "missing")
+	if !errors.Is(err, NotFound) {
+		t.Errorf("Expected errors.Is(err, NotFound) to hold for a missing key, got %s", err)
+	}
+	var le *LookupError
+	if !errors.As(err, &le) || le.Section != "foo" || le.Key != "missing" {
+		t.Errorf("Expected a *LookupError naming [foo] missing, got %+v", le)
+	}
+
+	_, err = conf.GetBool("foo", "bar")
+	if !errors.Is(err, NotBool) {
+		t.Errorf("Expected errors.Is(err, NotBool) to hold for an unparsable bool, got %s", err)
+	}
+
+	_, err = conf.GetInt("foo", "bar")
+	if !errors.As(err, &le) || le.Section != "foo" || le.Key != "bar" {
+		t.Errorf("Expected a *LookupError naming [foo] bar for an unparsable int, got %+v, %s", le, err)
+	}
+
+	_, err = conf.GetFloat("foo", "bar")
+	if !errors.As(err, &le) || le.Section != "foo" || le.Key != "bar" {
+		t.Errorf("Expected a *LookupError naming [foo] bar for an unparsable float, got %+v, %s", le, err)
+	}
 }